@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// proxyConfig holds the operator-tunable defaults that used to be hardcoded
+// constants, loaded once at startup from config.yaml.
+type proxyConfig struct {
+	MaxContextLength int                 `yaml:"max_context_length"`
+	TrustedWorkers   bool                `yaml:"trusted_workers"`
+	SlowWorkers      bool                `yaml:"slow_workers"`
+	Workers          []string            `yaml:"workers"`
+	Models           []string            `yaml:"models"`
+	ModelAliases     map[string][]string `yaml:"model_aliases"`
+}
+
+// defaultConfig is used for any field not set in config.yaml, and for the
+// whole config when the file doesn't exist.
+var defaultConfig = proxyConfig{
+	MaxContextLength: 1024,
+	TrustedWorkers:   false,
+	SlowWorkers:      true,
+}
+
+var config = defaultConfig
+
+// loadConfig loads config.yaml into the package-level config, falling back
+// to defaultConfig if the file doesn't exist.
+func loadConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cfg := defaultConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return err
+	}
+	config = cfg
+	return nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	koboldCancelURL       = "https://horde.koboldai.net/api/v2/generate/text/status/"
+	defaultRequestTimeout = 5 * time.Minute
+	maxPollRetries        = 5
+	maxPollInterval       = 15 * time.Second
+	minPollInterval       = 1 * time.Second
+)
+
+var (
+	metricQueueDepth = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "horde_proxy_queue_depth",
+		Help: "queue_position reported by Horde polls, observed across all in-flight jobs.",
+	})
+	metricWaitTime = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "horde_proxy_wait_time_seconds",
+		Help: "wait_time reported by Horde polls, in seconds.",
+	})
+	metricKudosSpent = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "horde_proxy_kudos_spent_total",
+		Help: "Total kudos spent across all completed Horde jobs.",
+	})
+	metricJobsSucceeded = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "horde_proxy_jobs_succeeded_total",
+		Help: "Total Horde jobs that completed successfully.",
+	})
+	metricJobsFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "horde_proxy_jobs_failed_total",
+		Help: "Total Horde jobs that failed, timed out, or were cancelled.",
+	})
+)
+
+// HordeClient submits and polls Horde text-generation jobs, one job per
+// Generate call, honoring context cancellation so a disconnected client
+// doesn't leave the job (or a polling goroutine) running forever.
+type HordeClient struct {
+	httpClient     *http.Client
+	requestTimeout time.Duration
+}
+
+// NewHordeClient builds a HordeClient with its per-request timeout read
+// from the HORDE_REQUEST_TIMEOUT_SECONDS env var, falling back to
+// defaultRequestTimeout if unset or invalid.
+func NewHordeClient() *HordeClient {
+	timeout := defaultRequestTimeout
+	if v := os.Getenv("HORDE_REQUEST_TIMEOUT_SECONDS"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			timeout = time.Duration(secs) * time.Second
+		}
+	}
+	return &HordeClient{
+		httpClient:     &http.Client{},
+		requestTimeout: timeout,
+	}
+}
+
+var hordeClient = NewHordeClient()
+
+// Generate submits koboldReq to Horde and polls it to completion, cancelling
+// the Horde job if ctx is cancelled (e.g. the client disconnected) and
+// invoking onUpdate with the text generated so far after every poll.
+// onUpdate may be nil.
+func (c *HordeClient) Generate(ctx context.Context, koboldReq koboldAIRequest, apiKey string, onUpdate func(text string)) (koboldAIPollResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout)
+	defer cancel()
+
+	id, err := c.submit(ctx, koboldReq, apiKey)
+	if err != nil {
+		metricJobsFailed.Inc()
+		return koboldAIPollResponse{}, err
+	}
+
+	result, err := c.poll(ctx, id, apiKey, onUpdate)
+	if err != nil {
+		c.cancelJob(id, apiKey)
+		metricJobsFailed.Inc()
+		return koboldAIPollResponse{}, err
+	}
+
+	metricJobsSucceeded.Inc()
+	metricKudosSpent.Add(float64(result.Kudos))
+	return result, nil
+}
+
+func (c *HordeClient) submit(ctx context.Context, koboldReq koboldAIRequest, apiKey string) (string, error) {
+	reqBody, err := json.Marshal(koboldReq)
+	if err != nil {
+		return "", fmt.Errorf("marshal horde request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", koboldAPIURL, strings.NewReader(string(reqBody)))
+	if err != nil {
+		return "", fmt.Errorf("create horde request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("apikey", apiKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("submit horde job: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("submit horde job: status %d: %s", resp.StatusCode, readErrorBody(resp))
+	}
+
+	var jsonResponse koboldAIAsyncResponse
+	if err := json.NewDecoder(resp.Body).Decode(&jsonResponse); err != nil {
+		return "", fmt.Errorf("decode horde submit response: %w", err)
+	}
+	return jsonResponse.ID, nil
+}
+
+// poll polls the Horde status endpoint until the job is done, adapting its
+// sleep interval to the reported wait_time/queue_position (polling often
+// near completion, rarely deep in queue) and retrying transient GET
+// failures with exponential backoff honoring Retry-After.
+func (c *HordeClient) poll(ctx context.Context, id string, apiKey string, onUpdate func(text string)) (koboldAIPollResponse, error) {
+	statusEndpoint := koboldStatusURL + id
+	interval := minPollInterval
+
+	for {
+		select {
+		case <-ctx.Done():
+			return koboldAIPollResponse{}, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		jsonResponse, err := c.getStatusWithRetry(ctx, statusEndpoint)
+		if err != nil {
+			return koboldAIPollResponse{}, err
+		}
+
+		metricQueueDepth.Observe(float64(jsonResponse.QueuePosition))
+		metricWaitTime.Observe(float64(jsonResponse.WaitTime))
+
+		if onUpdate != nil && len(jsonResponse.Generations) > 0 {
+			onUpdate(jsonResponse.Generations[0].Text)
+		}
+
+		if jsonResponse.Faulted {
+			return koboldAIPollResponse{}, fmt.Errorf("horde job %s faulted", id)
+		}
+		if jsonResponse.Done {
+			return jsonResponse, nil
+		}
+
+		interval = nextPollInterval(jsonResponse)
+	}
+}
+
+// nextPollInterval polls frequently as a job nears the front of the queue
+// or finishes processing, and backs off when it's still deep in queue.
+func nextPollInterval(resp koboldAIPollResponse) time.Duration {
+	if resp.Processing > 0 {
+		return minPollInterval
+	}
+	switch {
+	case resp.QueuePosition <= 1:
+		return minPollInterval
+	case resp.QueuePosition <= 5:
+		return 3 * time.Second
+	default:
+		interval := time.Duration(resp.WaitTime/4) * time.Second
+		if interval > maxPollInterval {
+			return maxPollInterval
+		}
+		if interval < minPollInterval {
+			return minPollInterval
+		}
+		return interval
+	}
+}
+
+// getStatusWithRetry GETs the status endpoint, retrying on 429/5xx with
+// exponential backoff (honoring Retry-After when present) since those GETs
+// are idempotent and safe to repeat.
+func (c *HordeClient) getStatusWithRetry(ctx context.Context, statusEndpoint string) (koboldAIPollResponse, error) {
+	backoff := 500 * time.Millisecond
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", statusEndpoint, nil)
+		if err != nil {
+			return koboldAIPollResponse{}, fmt.Errorf("create horde poll request: %w", err)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			if attempt >= maxPollRetries {
+				return koboldAIPollResponse{}, fmt.Errorf("polling horde: %w", err)
+			}
+			if !sleepCtx(ctx, backoff) {
+				return koboldAIPollResponse{}, ctx.Err()
+			}
+			backoff *= 2
+			continue
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			retryAfter := retryAfterDuration(resp.Header.Get("Retry-After"), backoff)
+			resp.Body.Close()
+			if attempt >= maxPollRetries {
+				return koboldAIPollResponse{}, fmt.Errorf("polling horde: status %d after %d retries", resp.StatusCode, attempt)
+			}
+			if !sleepCtx(ctx, retryAfter) {
+				return koboldAIPollResponse{}, ctx.Err()
+			}
+			backoff *= 2
+			continue
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			body := readErrorBody(resp)
+			resp.Body.Close()
+			return koboldAIPollResponse{}, fmt.Errorf("polling horde: status %d: %s", resp.StatusCode, body)
+		}
+
+		var jsonResponse koboldAIPollResponse
+		decodeErr := json.NewDecoder(resp.Body).Decode(&jsonResponse)
+		resp.Body.Close()
+		if decodeErr != nil {
+			return koboldAIPollResponse{}, fmt.Errorf("decode horde poll response: %w", decodeErr)
+		}
+		return jsonResponse, nil
+	}
+}
+
+// readErrorBody reads and trims a non-2xx response body for use in an error
+// message; Horde error responses are short JSON or plain text, so this
+// doesn't attempt to decode them structurally.
+func readErrorBody(resp *http.Response) string {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil || len(body) == 0 {
+		return resp.Status
+	}
+	return strings.TrimSpace(string(body))
+}
+
+func retryAfterDuration(header string, fallback time.Duration) time.Duration {
+	if header == "" {
+		return fallback
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	return fallback
+}
+
+// sleepCtx sleeps for d, returning false early if ctx is cancelled first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// cancelJob deletes an in-flight Horde job, e.g. because the client
+// disconnected or the request timed out. Best-effort: failures are logged,
+// not returned, since the caller already has an error of its own to report.
+func (c *HordeClient) cancelJob(id string, apiKey string) {
+	req, err := http.NewRequest("DELETE", koboldCancelURL+id, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error creating horde cancel request:", err)
+		return
+	}
+	req.Header.Set("apikey", apiKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error cancelling horde job:", err)
+		return
+	}
+	resp.Body.Close()
+}
@@ -1,38 +1,81 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
-	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type openAIChatRequest struct {
-	Model    string              `json:"model"`
-	Messages []openAIChatMessage `json:"messages"`
+	Model                string              `json:"model"`
+	Messages             []openAIChatMessage `json:"messages"`
+	MaxTokens            int                 `json:"max_tokens"`
+	Stream               bool                `json:"stream"`
+	Tools                []openAITool        `json:"tools,omitempty"`
+	ToolChoice           interface{}         `json:"tool_choice,omitempty"`
+	Temperature          float64             `json:"temperature"`
+	TopP                 float64             `json:"top_p"`
+	N                    int                 `json:"n"`
+	Stop                 []string            `json:"stop,omitempty"`
+	FrequencyPenalty     float64             `json:"frequency_penalty"`
+	PresencePenalty      float64             `json:"presence_penalty"`
+	Seed                 int                 `json:"seed,omitempty"`
+	LogitBias            map[string]int      `json:"logit_bias,omitempty"`
+	TopK                 int                 `json:"top_k,omitempty"`
+	TopA                 float64             `json:"top_a,omitempty"`
+	Tfs                  float64             `json:"tfs,omitempty"`
+	Typical              float64             `json:"typical,omitempty"`
+	RepPenRange          int                 `json:"rep_pen_range,omitempty"`
+	RepPenSlope          float64             `json:"rep_pen_slope,omitempty"`
+	Mirostat             int                 `json:"mirostat,omitempty"`
+	MirostatTau          float64             `json:"mirostat_tau,omitempty"`
+	MirostatEta          float64             `json:"mirostat_eta,omitempty"`
+	SamplerOrder         []int               `json:"sampler_order,omitempty"`
+	Singleline           bool                `json:"singleline,omitempty"`
+	UseDefaultBadWordIDs bool                `json:"use_default_badwordsids,omitempty"`
 }
 
 type openAIChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []openAIToolCall `json:"tool_calls,omitempty"`
 }
 
 type openAICompletionRequest struct {
-	Model       string   `json:"model"`
-	Prompt      string   `json:"prompt"`
-	MaxTokens   int      `json:"max_tokens"`
-	Temperature float64  `json:"temperature"`
-	TopP        float64  `json:"top_p"`
-	N           int      `json:"n"`
-	Stream      bool     `json:"stream"`
-	Logprobs    *int     `json:"logprobs,omitempty"`
-	Stop        []string `json:"stop,omitempty"`
+	Model                string         `json:"model"`
+	Prompt               string         `json:"prompt"`
+	MaxTokens            int            `json:"max_tokens"`
+	Temperature          float64        `json:"temperature"`
+	TopP                 float64        `json:"top_p"`
+	N                    int            `json:"n"`
+	Stream               bool           `json:"stream"`
+	Logprobs             *int           `json:"logprobs,omitempty"`
+	Stop                 []string       `json:"stop,omitempty"`
+	FrequencyPenalty     float64        `json:"frequency_penalty"`
+	PresencePenalty      float64        `json:"presence_penalty"`
+	Seed                 int            `json:"seed,omitempty"`
+	LogitBias            map[string]int `json:"logit_bias,omitempty"`
+	TopK                 int            `json:"top_k,omitempty"`
+	TopA                 float64        `json:"top_a,omitempty"`
+	Tfs                  float64        `json:"tfs,omitempty"`
+	Typical              float64        `json:"typical,omitempty"`
+	RepPenRange          int            `json:"rep_pen_range,omitempty"`
+	RepPenSlope          float64        `json:"rep_pen_slope,omitempty"`
+	Mirostat             int            `json:"mirostat,omitempty"`
+	MirostatTau          float64        `json:"mirostat_tau,omitempty"`
+	MirostatEta          float64        `json:"mirostat_eta,omitempty"`
+	SamplerOrder         []int          `json:"sampler_order,omitempty"`
+	Singleline           bool           `json:"singleline,omitempty"`
+	UseDefaultBadWordIDs bool           `json:"use_default_badwordsids,omitempty"`
 }
 
 type generation struct {
@@ -84,12 +127,32 @@ type koboldAIRequest struct {
 	Prompt         string   `json:"prompt"`
 	Models         []string `json:"models"`
 	TrustedWorkers bool     `json:"trusted_workers"`
+	SlowWorkers    bool     `json:"slow_workers"`
+	Workers        []string `json:"workers,omitempty"`
 	Params         params   `json:"params"`
 }
 
 type params struct {
-	MaxContextLength int `json:"max_context_length"`
-	MaxLength        int `json:"max_length"`
+	MaxContextLength     int      `json:"max_context_length"`
+	MaxLength            int      `json:"max_length"`
+	StopSequence         []string `json:"stop_sequence,omitempty"`
+	Grammar              string   `json:"grammar,omitempty"`
+	Temperature          float64  `json:"temperature,omitempty"`
+	TopP                 float64  `json:"top_p,omitempty"`
+	TopK                 int      `json:"top_k,omitempty"`
+	TopA                 float64  `json:"top_a,omitempty"`
+	Tfs                  float64  `json:"tfs,omitempty"`
+	Typical              float64  `json:"typical,omitempty"`
+	RepPen               float64  `json:"rep_pen,omitempty"`
+	Seed                 int      `json:"seed,omitempty"`
+	RepPenRange          int      `json:"rep_pen_range,omitempty"`
+	RepPenSlope          float64  `json:"rep_pen_slope,omitempty"`
+	Mirostat             int      `json:"mirostat,omitempty"`
+	MirostatTau          float64  `json:"mirostat_tau,omitempty"`
+	MirostatEta          float64  `json:"mirostat_eta,omitempty"`
+	SamplerOrder         []int    `json:"sampler_order,omitempty"`
+	Singleline           bool     `json:"singleline,omitempty"`
+	UseDefaultBadWordIDs bool     `json:"use_default_badwordsids,omitempty"`
 }
 
 type koboldAIPollResponse struct {
@@ -120,8 +183,19 @@ const (
 func main() {
 	router := mux.NewRouter()
 
+	if err := loadConfig("config.yaml"); err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading config:", err)
+	}
+	startModelRegistry()
+	if err := templates.loadTemplateConfig("templates.yaml"); err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading template config:", err)
+	}
+
 	router.HandleFunc("/v1/chat/completions", chatCompletionHandler).Methods("POST")
 	router.HandleFunc("/v1/completions", completionHandler).Methods("POST")
+	router.HandleFunc("/v1/models", listModelsHandler).Methods("GET")
+	router.HandleFunc("/v1/models/{id}", getModelHandler).Methods("GET")
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
 
 	http.ListenAndServe(":8080", router)
 }
@@ -141,18 +215,34 @@ func chatCompletionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	koboldReq := convertOpenAIChatRequestToKobold(chatReq)
-	koboldResp, err := callKoboldAPI(koboldReq, apiKey)
+	koboldReq, promptTokens, tools := convertOpenAIChatRequestToKobold(chatReq)
+
+	n := chatReq.N
+	if n < 1 {
+		n = 1
+	}
+
+	if chatReq.Stream {
+		if n > 1 {
+			http.Error(w, "n > 1 is not supported together with stream", http.StatusBadRequest)
+			return
+		}
+		streamChatCompletion(w, r, koboldReq, apiKey, chatReq.Model, tools)
+		return
+	}
+
+	koboldResps, err := callKoboldAPIN(r.Context(), koboldReq, apiKey, n)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error calling Kobold API: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	chatResp := convertKoboldResponseToOpenAIChatResponse(koboldResp)
+	chatResp := convertKoboldResponsesToOpenAIChatResponse(koboldResps, chatReq.Model, promptTokens, tools)
 
 	fmt.Fprintln(os.Stdout, chatResp)
 
+	w.Header().Set("X-Horde-Kudos-Consumed", fmt.Sprintf("%.2f", totalKudos(koboldResps)))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(chatResp)
 }
@@ -172,179 +262,254 @@ func completionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	koboldReq := convertOpenAICompletionRequestToKobold(completionReq)
-	koboldResp, err := callKoboldAPI(koboldReq, apiKey)
+	koboldReq, promptTokens := convertOpenAICompletionRequestToKobold(completionReq)
+
+	n := completionReq.N
+	if n < 1 {
+		n = 1
+	}
+
+	if completionReq.Stream {
+		if n > 1 {
+			http.Error(w, "n > 1 is not supported together with stream", http.StatusBadRequest)
+			return
+		}
+		streamCompletion(w, r, koboldReq, apiKey, completionReq.Model)
+		return
+	}
+
+	koboldResps, err := callKoboldAPIN(r.Context(), koboldReq, apiKey, n)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	completionResp := convertKoboldResponseToOpenAICompletionResponse(koboldResp)
-	//w.Header().Set("Content-Type", "application/json")
+	completionResp := convertKoboldResponsesToOpenAICompletionResponse(koboldResps, completionReq.Model, promptTokens)
+	w.Header().Set("X-Horde-Kudos-Consumed", fmt.Sprintf("%.2f", totalKudos(koboldResps)))
 	json.NewEncoder(w).Encode(completionResp)
 }
 
-func convertOpenAIChatRequestToKobold(chatReq openAIChatRequest) koboldAIRequest {
-	prompt := ""
-	for _, message := range chatReq.Messages {
-		prompt += message.Role + ": " + message.Content + "\n"
+func convertOpenAIChatRequestToKobold(chatReq openAIChatRequest) (koboldAIRequest, int, []openAITool) {
+	if len(chatReq.LogitBias) > 0 {
+		fmt.Fprintln(os.Stderr, "Warning: logit_bias is accepted but has no Horde equivalent and will not affect generation")
 	}
 
-	return koboldAIRequest{
-		Prompt:         prompt,
-		Models:         []string{chatReq.Model},
-		TrustedWorkers: false,
-		Params: params{
-			MaxContextLength: 1024,
-			MaxLength:        100,
-		},
-	}
-}
+	tools := resolveToolChoice(chatReq.ToolChoice, chatReq.Tools)
 
-func convertOpenAICompletionRequestToKobold(completionReq openAICompletionRequest) koboldAIRequest {
-	return koboldAIRequest{
-		Prompt:         completionReq.Prompt,
-		Models:         []string{completionReq.Model},
-		TrustedWorkers: false,
-		Params: params{
-			MaxContextLength: 1024,
-			MaxLength:        completionReq.MaxTokens,
-		},
+	messages := chatReq.Messages
+	if len(tools) > 0 {
+		messages = append([]openAIChatMessage{{Role: "system", Content: buildToolSystemPrompt(tools)}}, messages...)
 	}
-}
 
-func callKoboldAPI(koboldReq koboldAIRequest, apiKey string) (koboldAIPollResponse, error) {
-	fmt.Printf("Req: %+v\n", koboldReq)
-	reqBody, err := json.Marshal(koboldReq)
-	if err != nil {
-		_, file, line, _ := runtime.Caller(0)
-		errMsg := fmt.Sprintf("Error while marshal request at %s:%d: %v", file, line, err)
-		fmt.Fprintln(os.Stderr, errMsg)
-		return koboldAIPollResponse{}, err
-	}
+	template := templates.templateFor(chatReq.Model)
+	prompt := template.Render(messages)
 
-	req, err := http.NewRequest("POST", koboldAPIURL, strings.NewReader(string(reqBody)))
-	if err != nil {
-		_, file, line, _ := runtime.Caller(0)
-		errMsg := fmt.Sprintf("Error while creating request at %s:%d: %v", file, line, err)
-		fmt.Fprintln(os.Stderr, errMsg)
-		return koboldAIPollResponse{}, err
+	grammar := ""
+	if len(tools) > 0 {
+		g, err := toolsGrammar(tools)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error building tools grammar:", err)
+		} else {
+			grammar = g
+		}
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("apikey", apiKey)
+	stop := append([]string{}, template.StopSequences()...)
+	stop = append(stop, chatReq.Stop...)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	fmt.Fprintln(os.Stdout, "Sending request to horde")
-	if err != nil {
-		_, file, line, _ := runtime.Caller(0)
-		errMsg := fmt.Sprintf("Error while querying horde at %s:%d: %v", file, line, err)
-		fmt.Fprintln(os.Stderr, errMsg)
-		return koboldAIPollResponse{}, err
-	}
-	defer resp.Body.Close()
+	maxLength, promptTokens := enforcedMaxTokens(chatReq.Model, prompt, chatReq.MaxTokens, config.MaxContextLength, 100)
 
-	var jsonResponse koboldAIAsyncResponse
-	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
-	if err != nil {
-		_, file, line, _ := runtime.Caller(0)
-		errMsg := fmt.Sprintf("Error occurred at %s:%d: %v", file, line, err)
-		fmt.Fprintln(os.Stderr, errMsg)
-		return koboldAIPollResponse{}, err
-	}
-	fmt.Printf("Resp: %+v\n", jsonResponse)
+	return koboldAIRequest{
+		Prompt:         prompt,
+		Models:         registry.resolveHordeModels(chatReq.Model),
+		TrustedWorkers: config.TrustedWorkers,
+		SlowWorkers:    config.SlowWorkers,
+		Workers:        config.Workers,
+		Params: params{
+			MaxContextLength:     config.MaxContextLength,
+			MaxLength:            maxLength,
+			StopSequence:         stop,
+			Grammar:              grammar,
+			Temperature:          chatReq.Temperature,
+			TopP:                 chatReq.TopP,
+			RepPen:               repPenFromPenalties(chatReq.FrequencyPenalty, chatReq.PresencePenalty),
+			Seed:                 chatReq.Seed,
+			TopK:                 chatReq.TopK,
+			TopA:                 chatReq.TopA,
+			Tfs:                  chatReq.Tfs,
+			Typical:              chatReq.Typical,
+			RepPenRange:          chatReq.RepPenRange,
+			RepPenSlope:          chatReq.RepPenSlope,
+			Mirostat:             chatReq.Mirostat,
+			MirostatTau:          chatReq.MirostatTau,
+			MirostatEta:          chatReq.MirostatEta,
+			SamplerOrder:         chatReq.SamplerOrder,
+			Singleline:           chatReq.Singleline,
+			UseDefaultBadWordIDs: chatReq.UseDefaultBadWordIDs,
+		},
+	}, promptTokens, tools
+}
 
-	fmt.Fprintln(os.Stdout, "Polling horde with job id ", jsonResponse.ID)
-	result, err := pollKoboldAPI(jsonResponse.ID)
-	if err != nil {
-		errMsg := fmt.Sprintf("Error polling horde: %v", err)
-		fmt.Fprintln(os.Stderr, errMsg)
-		return koboldAIPollResponse{}, err
+func convertOpenAICompletionRequestToKobold(completionReq openAICompletionRequest) (koboldAIRequest, int) {
+	if len(completionReq.LogitBias) > 0 {
+		fmt.Fprintln(os.Stderr, "Warning: logit_bias is accepted but has no Horde equivalent and will not affect generation")
 	}
 
-	return result, nil
-}
+	maxLength, promptTokens := enforcedMaxTokens(completionReq.Model, completionReq.Prompt, completionReq.MaxTokens, config.MaxContextLength, 100)
 
-func pollKoboldAPI(id string) (koboldAIPollResponse, error) {
-	statusEndpoint := koboldStatusURL + id
+	return koboldAIRequest{
+		Prompt:         completionReq.Prompt,
+		Models:         registry.resolveHordeModels(completionReq.Model),
+		TrustedWorkers: config.TrustedWorkers,
+		SlowWorkers:    config.SlowWorkers,
+		Workers:        config.Workers,
+		Params: params{
+			MaxContextLength:     config.MaxContextLength,
+			MaxLength:            maxLength,
+			StopSequence:         completionReq.Stop,
+			Temperature:          completionReq.Temperature,
+			TopP:                 completionReq.TopP,
+			RepPen:               repPenFromPenalties(completionReq.FrequencyPenalty, completionReq.PresencePenalty),
+			Seed:                 completionReq.Seed,
+			TopK:                 completionReq.TopK,
+			TopA:                 completionReq.TopA,
+			Tfs:                  completionReq.Tfs,
+			Typical:              completionReq.Typical,
+			RepPenRange:          completionReq.RepPenRange,
+			RepPenSlope:          completionReq.RepPenSlope,
+			Mirostat:             completionReq.Mirostat,
+			MirostatTau:          completionReq.MirostatTau,
+			MirostatEta:          completionReq.MirostatEta,
+			SamplerOrder:         completionReq.SamplerOrder,
+			Singleline:           completionReq.Singleline,
+			UseDefaultBadWordIDs: completionReq.UseDefaultBadWordIDs,
+		},
+	}, promptTokens
+}
 
-	for {
-		time.Sleep(2 * time.Second)
+func callKoboldAPI(ctx context.Context, koboldReq koboldAIRequest, apiKey string) (koboldAIPollResponse, error) {
+	return callKoboldAPIWithUpdates(ctx, koboldReq, apiKey, nil)
+}
 
-		resp, err := http.Get(statusEndpoint)
+// callKoboldAPIN runs n independent Horde jobs for koboldReq in parallel, so
+// that `n > 1` actually produces n distinct generations instead of silently
+// returning a single one.
+func callKoboldAPIN(ctx context.Context, koboldReq koboldAIRequest, apiKey string, n int) ([]koboldAIPollResponse, error) {
+	if n == 1 {
+		resp, err := callKoboldAPI(ctx, koboldReq, apiKey)
 		if err != nil {
-			errMsg := fmt.Sprintf("Error polling horde GET: %v", err)
-			fmt.Fprintln(os.Stderr, errMsg)
-			return koboldAIPollResponse{}, err
+			return nil, err
 		}
-		defer resp.Body.Close()
+		return []koboldAIPollResponse{resp}, nil
+	}
 
-		var jsonResponse koboldAIPollResponse
-		fmt.Fprintln(os.Stdout, jsonResponse)
-		err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
-		if err != nil {
-			errMsg := fmt.Sprintf("Error polling horde decode: %v", err)
-			fmt.Fprintln(os.Stderr, errMsg)
-			return koboldAIPollResponse{}, err
-		}
-		fmt.Printf("Resp: %+v\n", jsonResponse)
+	responses := make([]koboldAIPollResponse, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i], errs[i] = callKoboldAPI(ctx, koboldReq, apiKey)
+		}(i)
+	}
+	wg.Wait()
 
-		if jsonResponse.Done {
-			return jsonResponse, nil
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
 		}
 	}
+	return responses, nil
 }
 
-func convertKoboldResponseToOpenAIChatResponse(koboldResp koboldAIPollResponse) openAIChatResponse {
-	responseText := koboldResp.Generations[0].Text
-	assistantMessage := openAIChatMessage{
-		Role:    "assistant",
-		Content: responseText,
-	}
+// callKoboldAPIWithUpdates submits koboldReq to Horde and polls it to
+// completion via hordeClient, invoking onUpdate with the text generated so
+// far after every poll. onUpdate may be nil, in which case this behaves
+// like callKoboldAPI.
+func callKoboldAPIWithUpdates(ctx context.Context, koboldReq koboldAIRequest, apiKey string, onUpdate func(text string)) (koboldAIPollResponse, error) {
+	return hordeClient.Generate(ctx, koboldReq, apiKey, onUpdate)
+}
 
+// convertKoboldResponsesToOpenAIChatResponse builds one choice per Horde
+// response, as produced by the `n` parallel generations in callKoboldAPIN.
+// promptTokens is the tokenizer-computed count from convertOpenAIChatRequestToKobold,
+// since by this point only the generated text remains available per-choice.
+func convertKoboldResponsesToOpenAIChatResponse(koboldResps []koboldAIPollResponse, model string, promptTokens int, tools []openAITool) openAIChatResponse {
+	tokenizer := tokenizerForModel(model)
 	id, _ := uuid.NewUUID()
-	return openAIChatResponse{
+	resp := openAIChatResponse{
 		ID:      id.String(),
 		Object:  "chat.completion",
 		Created: int(time.Now().Unix()),
-		Choices: []openAIChatChoice{
-			{
-				Index:        0,
-				Message:      assistantMessage,
-				FinishReason: "stop",
-			},
-		},
-		Usage: openAIUsage{
-			PromptTokens:     len(koboldResp.Generations[0].Text),
-			CompletionTokens: len(responseText),
-			TotalTokens:      len(koboldResp.Generations[0].Text) + len(responseText),
-		},
 	}
-}
 
-func convertKoboldResponseToOpenAICompletionResponse(koboldResp koboldAIPollResponse) openAICompletionResponse {
-	responseText := koboldResp.Generations[0].Text
+	for i, koboldResp := range koboldResps {
+		responseText := koboldResp.Generations[0].Text
+		assistantMessage := openAIChatMessage{
+			Role:    "assistant",
+			Content: responseText,
+		}
+		finishReason := "stop"
+
+		if len(tools) > 0 {
+			if call, ok := detectToolCall(responseText, tools); ok {
+				assistantMessage.Content = ""
+				assistantMessage.ToolCalls = []openAIToolCall{call}
+				finishReason = "tool_calls"
+			}
+		}
+
+		resp.Choices = append(resp.Choices, openAIChatChoice{
+			Index:        i,
+			Message:      assistantMessage,
+			FinishReason: finishReason,
+		})
+		resp.Usage.CompletionTokens += tokenizer.CountTokens(responseText)
+	}
+	resp.Usage.PromptTokens = promptTokens
+	resp.Usage.TotalTokens = resp.Usage.PromptTokens + resp.Usage.CompletionTokens
+
+	return resp
+}
 
+// convertKoboldResponsesToOpenAICompletionResponse builds one choice per
+// Horde response, as produced by the `n` parallel generations in
+// callKoboldAPIN. promptTokens is the tokenizer-computed count from
+// convertOpenAICompletionRequestToKobold.
+func convertKoboldResponsesToOpenAICompletionResponse(koboldResps []koboldAIPollResponse, model string, promptTokens int) openAICompletionResponse {
+	tokenizer := tokenizerForModel(model)
 	id, _ := uuid.NewUUID()
-	return openAICompletionResponse{
+	resp := openAICompletionResponse{
 		ID:      id.String(),
 		Object:  "text.completion",
 		Created: int(time.Now().Unix()),
-		Choices: []openAICompletionChoice{
-			{
-				Text:         responseText,
-				Index:        0,
-				Logprobs:     nil,
-				FinishReason: "stop",
-			},
-		},
-		Model: "davinci-codex",
-		Usage: openAIUsage{
-			PromptTokens:     0,
-			CompletionTokens: 0,
-			TotalTokens:      0,
-		},
+		Model:   model,
+	}
+
+	for i, koboldResp := range koboldResps {
+		responseText := koboldResp.Generations[0].Text
+		resp.Choices = append(resp.Choices, openAICompletionChoice{
+			Text:         responseText,
+			Index:        i,
+			Logprobs:     nil,
+			FinishReason: "stop",
+		})
+		resp.Usage.CompletionTokens += tokenizer.CountTokens(responseText)
+	}
+	resp.Usage.PromptTokens = promptTokens
+	resp.Usage.TotalTokens = resp.Usage.PromptTokens + resp.Usage.CompletionTokens
+
+	return resp
+}
+
+// totalKudos sums the kudos spent across a set of Horde poll responses, for
+// the X-Horde-Kudos-Consumed response header.
+func totalKudos(koboldResps []koboldAIPollResponse) float32 {
+	var total float32
+	for _, r := range koboldResps {
+		total += r.Kudos
 	}
+	return total
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	hordeModelsURL        = "https://horde.koboldai.net/api/v2/status/models?type=text"
+	modelRegistryInterval = 30 * time.Second
+	modelsRequestTimeout  = 10 * time.Second
+)
+
+// modelsHTTPClient is used for the model-registry refresh so a slow or
+// unreachable Horde API can't hang startup or wedge the refresh ticker.
+var modelsHTTPClient = &http.Client{Timeout: modelsRequestTimeout}
+
+// hordeModelStatus mirrors a single entry of Horde's
+// /api/v2/status/models?type=text response.
+type hordeModelStatus struct {
+	Name        string  `json:"name"`
+	Count       int     `json:"count"`
+	Queued      int     `json:"queued"`
+	Jobs        int     `json:"jobs"`
+	ETA         int     `json:"eta"`
+	Type        string  `json:"type"`
+	Performance float64 `json:"performance"`
+}
+
+// openAIModel is a single entry of the OpenAI-compatible model list, with
+// Horde-specific worker/queue info attached under an extension field so
+// clients that ignore unknown fields still work.
+type openAIModel struct {
+	ID      string           `json:"id"`
+	Object  string           `json:"object"`
+	Created int              `json:"created"`
+	OwnedBy string           `json:"owned_by"`
+	Horde   *hordeModelExtra `json:"horde,omitempty"`
+}
+
+// hordeModelExtra carries the Horde worker-discovery data that has no
+// equivalent in the OpenAI model schema. Horde's status/models endpoint
+// doesn't report a per-model max context length (that lives on individual
+// workers, not the aggregate status this registry polls), so there's no
+// max_context_length field here -- config.MaxContextLength is the only
+// context-length knob the proxy has.
+type hordeModelExtra struct {
+	QueuedJobs  int     `json:"queued_jobs"`
+	WorkerCount int     `json:"worker_count"`
+	ETASeconds  int     `json:"eta_seconds"`
+	Performance float64 `json:"performance"`
+}
+
+type openAIModelList struct {
+	Object string        `json:"object"`
+	Data   []openAIModel `json:"data"`
+}
+
+// modelRegistry caches the set of models Horde workers currently advertise,
+// refreshed periodically by a background goroutine so request handlers never
+// block on the Horde API.
+type modelRegistry struct {
+	mu       sync.RWMutex
+	models   map[string]openAIModel
+	lastSync time.Time
+}
+
+var registry = &modelRegistry{
+	models: map[string]openAIModel{},
+}
+
+// defaultAliasMap translates friendly OpenAI model names to the list of
+// Horde models that should be offered for that request. It's the fallback
+// used when config.yaml doesn't declare a model_aliases entry for the
+// requested name.
+var defaultAliasMap = map[string][]string{
+	"gpt-3.5-turbo": {"koboldcpp/airoboros-33b", "koboldcpp/llama2-13b"},
+	"gpt-4":         {"koboldcpp/llama2-70b"},
+}
+
+// startModelRegistry runs one synchronous refresh before launching the
+// background refresh goroutine, so the server doesn't answer /v1/models with
+// an empty list immediately after startup. If that first refresh fails, it
+// is not retried before returning; the list stays empty until the next tick.
+func startModelRegistry() {
+	registry.refresh()
+	go func() {
+		ticker := time.NewTicker(modelRegistryInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			registry.refresh()
+		}
+	}()
+}
+
+func (r *modelRegistry) refresh() {
+	resp, err := modelsHTTPClient.Get(hordeModelsURL)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error refreshing model registry:", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var statuses []hordeModelStatus
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		fmt.Fprintln(os.Stderr, "Error decoding model registry response:", err)
+		return
+	}
+
+	models := make(map[string]openAIModel, len(statuses))
+	for _, s := range statuses {
+		models[s.Name] = openAIModel{
+			ID:      s.Name,
+			Object:  "model",
+			Created: int(time.Now().Unix()),
+			OwnedBy: "horde",
+			Horde: &hordeModelExtra{
+				QueuedJobs:  s.Queued,
+				WorkerCount: s.Count,
+				ETASeconds:  s.ETA,
+				Performance: s.Performance,
+			},
+		}
+	}
+
+	r.mu.Lock()
+	r.models = models
+	r.lastSync = time.Now()
+	r.mu.Unlock()
+}
+
+// resolveHordeModels translates an OpenAI-style model name into the list of
+// Horde models to request. An alias configured via config.yaml's
+// model_aliases takes precedence, falling back to defaultAliasMap; if
+// neither has an entry and the name matches a known Horde model it is used
+// verbatim; otherwise, if an operator has configured a default model list,
+// that list is used; only when none of those apply is the name passed
+// through unchanged, so self-hosted or custom Horde models keep working as
+// long as config.Models is left unset.
+func (r *modelRegistry) resolveHordeModels(requested string) []string {
+	if models, ok := config.ModelAliases[requested]; ok {
+		return models
+	}
+	if models, ok := defaultAliasMap[requested]; ok {
+		return models
+	}
+
+	r.mu.RLock()
+	_, known := r.models[requested]
+	r.mu.RUnlock()
+	if known {
+		return []string{requested}
+	}
+
+	if len(config.Models) > 0 {
+		return config.Models
+	}
+
+	return []string{requested}
+}
+
+func (r *modelRegistry) list() []openAIModel {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]openAIModel, 0, len(r.models))
+	for _, m := range r.models {
+		list = append(list, m)
+	}
+	return list
+}
+
+func (r *modelRegistry) get(id string) (openAIModel, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m, ok := r.models[id]
+	return m, ok
+}
+
+func listModelsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAIModelList{
+		Object: "list",
+		Data:   registry.list(),
+	})
+}
+
+func getModelHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	model, ok := registry.get(id)
+	if !ok {
+		http.Error(w, fmt.Sprintf("model %q not found", id), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model)
+}
@@ -0,0 +1,18 @@
+package main
+
+// repPenFromPenalties maps OpenAI's frequency_penalty/presence_penalty
+// (each roughly in [-2, 2], 0 meaning "no penalty") onto KoboldAI's rep_pen
+// (a multiplier, 1.0 meaning "no penalty", commonly tuned in [1.0, 1.5]).
+// OpenAI's two separate penalties don't have a KoboldAI equivalent each, so
+// they're summed before mapping, which matches how most KoboldAI front
+// ends approximate OpenAI-style requests. The result is clamped to
+// KoboldAI's documented rep_pen >= 1.0 floor, since negative OpenAI
+// penalties would otherwise map to a meaningless or negative multiplier.
+func repPenFromPenalties(frequencyPenalty, presencePenalty float64) float64 {
+	combined := frequencyPenalty + presencePenalty
+	repPen := 1.0 + combined*0.25
+	if repPen < 1.0 {
+		repPen = 1.0
+	}
+	return repPen
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// openAIChatChunk is a single SSE frame of a streamed chat completion,
+// matching OpenAI's "chat.completion.chunk" object.
+type openAIChatChunk struct {
+	ID      string                  `json:"id"`
+	Object  string                  `json:"object"`
+	Created int                     `json:"created"`
+	Model   string                  `json:"model"`
+	Choices []openAIChatChunkChoice `json:"choices"`
+}
+
+type openAIChatChunkChoice struct {
+	Index        int               `json:"index"`
+	Delta        openAIChatMessage `json:"delta"`
+	FinishReason *string           `json:"finish_reason"`
+}
+
+// openAICompletionChunk is a single SSE frame of a streamed text completion,
+// matching OpenAI's "text_completion" chunk object.
+type openAICompletionChunk struct {
+	ID      string                   `json:"id"`
+	Object  string                   `json:"object"`
+	Created int                      `json:"created"`
+	Model   string                   `json:"model"`
+	Choices []openAICompletionChoice `json:"choices"`
+}
+
+// writeSSE writes a single "data: <payload>\n\n" frame and flushes it
+// immediately so the client sees it without buffering delay.
+func writeSSE(w http.ResponseWriter, flusher http.Flusher, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	flusher.Flush()
+	return nil
+}
+
+// streamChatCompletion streams a chat completion as OpenAI-compatible SSE
+// chunks. Since Horde only reports the full text-so-far on each poll, each
+// chunk's delta is the diff against the previous poll. When tools are
+// present, content deltas are withheld until the generation finishes, since
+// detectToolCall needs the complete text to tell a tool call apart from
+// plain content -- the same thing convertKoboldResponsesToOpenAIChatResponse
+// does for the non-streaming path.
+func streamChatCompletion(w http.ResponseWriter, r *http.Request, koboldReq koboldAIRequest, apiKey string, model string, tools []openAITool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	id, _ := uuid.NewUUID()
+	created := int(time.Now().Unix())
+	previousText := ""
+	finalText := ""
+
+	onUpdate := func(text string) {
+		finalText = text
+		if len(tools) > 0 {
+			return
+		}
+		if len(text) <= len(previousText) {
+			return
+		}
+		delta := text[len(previousText):]
+		previousText = text
+
+		chunk := openAIChatChunk{
+			ID:      id.String(),
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   model,
+			Choices: []openAIChatChunkChoice{
+				{
+					Index:        0,
+					Delta:        openAIChatMessage{Role: "assistant", Content: delta},
+					FinishReason: nil,
+				},
+			},
+		}
+		if err := writeSSE(w, flusher, chunk); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing SSE chunk:", err)
+		}
+	}
+
+	_, err := callKoboldAPIWithUpdates(r.Context(), koboldReq, apiKey, onUpdate)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error calling Kobold API:", err)
+		return
+	}
+
+	finishReason := "stop"
+	delta := openAIChatMessage{}
+	if len(tools) > 0 {
+		if call, ok := detectToolCall(finalText, tools); ok {
+			finishReason = "tool_calls"
+			delta = openAIChatMessage{ToolCalls: []openAIToolCall{call}}
+		} else {
+			delta = openAIChatMessage{Role: "assistant", Content: finalText}
+		}
+	}
+
+	finalChunk := openAIChatChunk{
+		ID:      id.String(),
+		Object:  "chat.completion.chunk",
+		Created: created,
+		Model:   model,
+		Choices: []openAIChatChunkChoice{
+			{
+				Index:        0,
+				Delta:        delta,
+				FinishReason: &finishReason,
+			},
+		},
+	}
+	if err := writeSSE(w, flusher, finalChunk); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing final SSE chunk:", err)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// streamCompletion streams a text completion as OpenAI-compatible SSE
+// chunks, diffing successive Horde poll responses the same way
+// streamChatCompletion does.
+func streamCompletion(w http.ResponseWriter, r *http.Request, koboldReq koboldAIRequest, apiKey string, model string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	id, _ := uuid.NewUUID()
+	created := int(time.Now().Unix())
+	previousText := ""
+
+	onUpdate := func(text string) {
+		if len(text) <= len(previousText) {
+			return
+		}
+		delta := text[len(previousText):]
+		previousText = text
+
+		chunk := openAICompletionChunk{
+			ID:      id.String(),
+			Object:  "text_completion",
+			Created: created,
+			Model:   model,
+			Choices: []openAICompletionChoice{
+				{
+					Text:         delta,
+					Index:        0,
+					FinishReason: "",
+				},
+			},
+		}
+		if err := writeSSE(w, flusher, chunk); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing SSE chunk:", err)
+		}
+	}
+
+	_, err := callKoboldAPIWithUpdates(r.Context(), koboldReq, apiKey, onUpdate)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error calling Kobold API:", err)
+		return
+	}
+
+	finalChunk := openAICompletionChunk{
+		ID:      id.String(),
+		Object:  "text_completion",
+		Created: created,
+		Model:   model,
+		Choices: []openAICompletionChoice{
+			{
+				Text:         "",
+				Index:        0,
+				FinishReason: "stop",
+			},
+		},
+	}
+	if err := writeSSE(w, flusher, finalChunk); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing final SSE chunk:", err)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
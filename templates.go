@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PromptTemplate renders a chat turn sequence into the single prompt string
+// a Horde text-generation worker expects, and reports the stop sequences
+// that mark the end of the model's turn so generation can be cut off
+// cleanly instead of running on into a hallucinated next turn.
+type PromptTemplate interface {
+	Render(messages []openAIChatMessage) string
+	StopSequences() []string
+}
+
+// Llama2ChatTemplate implements Meta's Llama-2-chat `[INST]`/`<<SYS>>` format.
+type Llama2ChatTemplate struct{}
+
+func (Llama2ChatTemplate) Render(messages []openAIChatMessage) string {
+	var system string
+	var sb strings.Builder
+	turnOpen := false
+
+	for _, m := range messages {
+		switch m.Role {
+		case "system":
+			system += m.Content + "\n"
+		case "user":
+			if turnOpen {
+				sb.WriteString(" [/INST]")
+				turnOpen = false
+			}
+			sb.WriteString("<s>[INST] ")
+			if system != "" {
+				sb.WriteString("<<SYS>>\n" + system + "\n<</SYS>>\n\n")
+				system = ""
+			}
+			sb.WriteString(m.Content)
+			turnOpen = true
+		case "assistant":
+			sb.WriteString(" [/INST] " + m.Content + " </s>")
+			turnOpen = false
+		}
+	}
+	if turnOpen {
+		sb.WriteString(" [/INST]")
+	}
+	return sb.String()
+}
+
+func (Llama2ChatTemplate) StopSequences() []string {
+	return []string{"</s>", "[INST]"}
+}
+
+// AlpacaTemplate implements the Stanford Alpaca instruction format.
+type AlpacaTemplate struct{}
+
+func (AlpacaTemplate) Render(messages []openAIChatMessage) string {
+	var system, instruction string
+	for _, m := range messages {
+		switch m.Role {
+		case "system":
+			system += m.Content + "\n"
+		case "user":
+			instruction += m.Content + "\n"
+		}
+	}
+
+	var sb strings.Builder
+	if system != "" {
+		sb.WriteString(strings.TrimSpace(system) + "\n\n")
+	}
+	sb.WriteString("### Instruction:\n" + strings.TrimSpace(instruction) + "\n\n### Response:\n")
+	return sb.String()
+}
+
+func (AlpacaTemplate) StopSequences() []string {
+	return []string{"### Instruction:"}
+}
+
+// ChatMLTemplate implements the `<|im_start|>`/`<|im_end|>` format used by
+// OpenAI's ChatML-tuned community models.
+type ChatMLTemplate struct{}
+
+func (ChatMLTemplate) Render(messages []openAIChatMessage) string {
+	var sb strings.Builder
+	for _, m := range messages {
+		sb.WriteString("<|im_start|>" + m.Role + "\n" + m.Content + "<|im_end|>\n")
+	}
+	sb.WriteString("<|im_start|>assistant\n")
+	return sb.String()
+}
+
+func (ChatMLTemplate) StopSequences() []string {
+	return []string{"<|im_end|>", "<|im_start|>"}
+}
+
+// VicunaTemplate implements lmsys Vicuna's "USER:"/"ASSISTANT:" format.
+type VicunaTemplate struct{}
+
+func (VicunaTemplate) Render(messages []openAIChatMessage) string {
+	var sb strings.Builder
+	for _, m := range messages {
+		switch m.Role {
+		case "system":
+			sb.WriteString(m.Content + "\n\n")
+		case "user":
+			sb.WriteString("USER: " + m.Content + "\n")
+		case "assistant":
+			sb.WriteString("ASSISTANT: " + m.Content + "</s>\n")
+		}
+	}
+	sb.WriteString("ASSISTANT: ")
+	return sb.String()
+}
+
+func (VicunaTemplate) StopSequences() []string {
+	return []string{"</s>", "USER:"}
+}
+
+// MetharmeTemplate implements PygmalionAI's Metharme `<|system|>`/`<|user|>`/
+// `<|model|>` format.
+type MetharmeTemplate struct{}
+
+func (MetharmeTemplate) Render(messages []openAIChatMessage) string {
+	var sb strings.Builder
+	for _, m := range messages {
+		switch m.Role {
+		case "system":
+			sb.WriteString("<|system|>" + m.Content)
+		case "user":
+			sb.WriteString("<|user|>" + m.Content)
+		case "assistant":
+			sb.WriteString("<|model|>" + m.Content)
+		}
+	}
+	sb.WriteString("<|model|>")
+	return sb.String()
+}
+
+func (MetharmeTemplate) StopSequences() []string {
+	return []string{"<|user|>", "<|system|>"}
+}
+
+// naiveTemplate is the original `role: content\n` concatenation, kept as the
+// fallback for models that don't match any known family or custom template.
+type naiveTemplate struct{}
+
+func (naiveTemplate) Render(messages []openAIChatMessage) string {
+	prompt := ""
+	for _, m := range messages {
+		prompt += m.Role + ": " + m.Content + "\n"
+	}
+	return prompt
+}
+
+func (naiveTemplate) StopSequences() []string { return nil }
+
+// customTemplateConfig is a single entry of the YAML template config file:
+// a regex matched against the requested model name, and a Go text/template
+// body rendering `{{.System}}`, `{{.Messages}}` (each with .Role/.Content)
+// into the final prompt.
+type customTemplateConfig struct {
+	ModelPattern string   `yaml:"model_pattern"`
+	Template     string   `yaml:"template"`
+	Stop         []string `yaml:"stop"`
+}
+
+type templatesConfig struct {
+	Templates []customTemplateConfig `yaml:"templates"`
+}
+
+// customTemplate renders messages through a user-supplied text/template.
+type customTemplate struct {
+	tmpl *template.Template
+	stop []string
+}
+
+type customTemplateData struct {
+	System   string
+	Messages []openAIChatMessage
+}
+
+func (c customTemplate) Render(messages []openAIChatMessage) string {
+	var systemParts []string
+	var rest []openAIChatMessage
+	for _, m := range messages {
+		if m.Role == "system" {
+			systemParts = append(systemParts, m.Content)
+			continue
+		}
+		rest = append(rest, m)
+	}
+	data := customTemplateData{
+		System:   strings.Join(systemParts, "\n"),
+		Messages: rest,
+	}
+
+	var sb strings.Builder
+	if err := c.tmpl.Execute(&sb, data); err != nil {
+		fmt.Fprintln(os.Stderr, "Error rendering custom template:", err)
+		return naiveTemplate{}.Render(messages)
+	}
+	return sb.String()
+}
+
+func (c customTemplate) StopSequences() []string { return c.stop }
+
+// templateRegistry holds the built-in per-family templates plus any custom
+// templates loaded from a YAML config file, matched against the requested
+// model name by regex (custom) or substring (built-in).
+type templateRegistry struct {
+	builtins map[string]PromptTemplate
+	custom   []compiledCustomTemplate
+}
+
+type compiledCustomTemplate struct {
+	pattern  *regexp.Regexp
+	template customTemplate
+}
+
+// builtinOrder fixes the iteration order for templateFor's substring match
+// against builtins, since Go's map iteration order is randomized and two
+// builtin keys could otherwise match the same model name on one run but not
+// the next.
+var builtinOrder = []string{"llama2-chat", "alpaca", "chatml", "vicuna", "metharme"}
+
+var templates = &templateRegistry{
+	builtins: map[string]PromptTemplate{
+		"llama2-chat": Llama2ChatTemplate{},
+		"alpaca":      AlpacaTemplate{},
+		"chatml":      ChatMLTemplate{},
+		"vicuna":      VicunaTemplate{},
+		"metharme":    MetharmeTemplate{},
+	},
+}
+
+// loadTemplateConfig loads custom templates from a YAML file and merges them
+// into the registry, matched before the built-in family templates. Missing
+// files are not an error -- the built-in templates are used as-is.
+func (r *templateRegistry) loadTemplateConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var cfg templatesConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return err
+	}
+
+	for _, entry := range cfg.Templates {
+		pattern, err := regexp.Compile(entry.ModelPattern)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error compiling template pattern %q: %v\n", entry.ModelPattern, err)
+			continue
+		}
+		tmpl, err := template.New(entry.ModelPattern).Parse(entry.Template)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing template for %q: %v\n", entry.ModelPattern, err)
+			continue
+		}
+		r.custom = append(r.custom, compiledCustomTemplate{
+			pattern:  pattern,
+			template: customTemplate{tmpl: tmpl, stop: entry.Stop},
+		})
+	}
+	return nil
+}
+
+// templateFor returns the PromptTemplate to use for the given requested
+// model name: a matching custom template first, then a built-in family
+// template matched by substring, then the naive fallback.
+func (r *templateRegistry) templateFor(model string) PromptTemplate {
+	for _, c := range r.custom {
+		if c.pattern.MatchString(model) {
+			return c.template
+		}
+	}
+
+	lower := strings.ToLower(model)
+	for _, key := range builtinOrder {
+		if strings.Contains(lower, key) {
+			return r.builtins[key]
+		}
+	}
+	switch {
+	case strings.Contains(lower, "llama-2") || strings.Contains(lower, "llama2"):
+		return r.builtins["llama2-chat"]
+	case strings.Contains(lower, "mistral") && strings.Contains(lower, "instruct"):
+		return r.builtins["llama2-chat"]
+	}
+
+	return naiveTemplate{}
+}
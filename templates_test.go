@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+func TestLlama2ChatTemplateRender(t *testing.T) {
+	cases := []struct {
+		name     string
+		messages []openAIChatMessage
+		want     string
+	}{
+		{
+			name: "single user turn, no system",
+			messages: []openAIChatMessage{
+				{Role: "user", Content: "hi"},
+			},
+			want: "<s>[INST] hi [/INST]",
+		},
+		{
+			name: "system message prepended to first user turn only",
+			messages: []openAIChatMessage{
+				{Role: "system", Content: "be terse"},
+				{Role: "user", Content: "hi"},
+				{Role: "assistant", Content: "hello"},
+				{Role: "user", Content: "bye"},
+			},
+			want: "<s>[INST] <<SYS>>\nbe terse\n\n<</SYS>>\n\nhi [/INST] hello </s><s>[INST] bye [/INST]",
+		},
+		{
+			name: "multiple system messages accumulate before the first user turn",
+			messages: []openAIChatMessage{
+				{Role: "system", Content: "be terse"},
+				{Role: "system", Content: "be polite"},
+				{Role: "user", Content: "hi"},
+			},
+			want: "<s>[INST] <<SYS>>\nbe terse\nbe polite\n\n<</SYS>>\n\nhi [/INST]",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := (Llama2ChatTemplate{}).Render(c.messages); got != c.want {
+				t.Errorf("Render() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLlama2ChatTemplateStopSequences(t *testing.T) {
+	want := []string{"</s>", "[INST]"}
+	got := (Llama2ChatTemplate{}).StopSequences()
+	if len(got) != len(want) {
+		t.Fatalf("StopSequences() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("StopSequences()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestTemplateForMatchesByFamily(t *testing.T) {
+	cases := []struct {
+		model string
+		want  PromptTemplate
+	}{
+		{"koboldcpp/llama2-13b", templates.builtins["llama2-chat"]},
+		{"some-llama-2-70b-finetune", templates.builtins["llama2-chat"]},
+		{"mistral-7b-instruct", templates.builtins["llama2-chat"]},
+		{"airoboros-33b-alpaca", templates.builtins["alpaca"]},
+		{"openhermes-chatml", templates.builtins["chatml"]},
+		{"unknown-model", naiveTemplate{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.model, func(t *testing.T) {
+			if got := templates.templateFor(c.model); got != c.want {
+				t.Errorf("templateFor(%q) = %#v, want %#v", c.model, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	tiktoken "github.com/pkoukk/tiktoken-go"
+)
+
+// approxTokenSafetyMargin is held back from the available completion length
+// whenever the prompt's token count is only a cl100k_base approximation
+// (i.e. exact is false), so an undercount can't let a completion run the
+// worker past its real context window.
+const approxTokenSafetyMargin = 32
+
+// Tokenizer counts how many tokens a model will see for a given piece of
+// text, so usage accounting and max_tokens enforcement reflect what the
+// model actually consumes instead of raw byte length.
+type Tokenizer interface {
+	CountTokens(text string) int
+	// Exact reports whether CountTokens reflects the model's real tokenizer,
+	// as opposed to the cl100k_base approximation used for non-OpenAI
+	// models.
+	Exact() bool
+}
+
+// tiktokenTokenizer counts tokens using cl100k_base, OpenAI's BPE encoding.
+// It's used for every model, OpenAI or not: a HuggingFace tokenizer.json
+// loader is the only way to get exact counts for Llama/Mistral models, and
+// none is implemented yet, so their usage numbers are cl100k_base
+// approximations rather than their real tokenizer's counts -- exact is only
+// true for models we know actually use cl100k_base.
+type tiktokenTokenizer struct {
+	enc   *tiktoken.Tiktoken
+	exact bool
+}
+
+func (t tiktokenTokenizer) CountTokens(text string) int {
+	return len(t.enc.Encode(text, nil, nil))
+}
+
+func (t tiktokenTokenizer) Exact() bool { return t.exact }
+
+// approxTokenizer is the fallback used when no BPE encoding could be
+// loaded (e.g. no network access to fetch tiktoken's rank file). It
+// estimates ~4 characters per token, the commonly cited rule of thumb for
+// English text, which is far closer to reality than counting raw bytes.
+type approxTokenizer struct{}
+
+func (approxTokenizer) CountTokens(text string) int {
+	if strings.TrimSpace(text) == "" {
+		return 0
+	}
+	return (len(text) + 3) / 4
+}
+
+func (approxTokenizer) Exact() bool { return false }
+
+var (
+	tokenizerMu    sync.Mutex
+	tokenizerCache = map[string]Tokenizer{}
+)
+
+// isOpenAIModel reports whether model is one of OpenAI's own models, the
+// only ones cl100k_base is actually the correct encoding for.
+func isOpenAIModel(model string) bool {
+	return strings.HasPrefix(model, "gpt-") || strings.HasPrefix(model, "text-")
+}
+
+// tokenizerForModel returns the Tokenizer to use for the given requested
+// model name, selecting and caching a tiktoken encoding on first use.
+func tokenizerForModel(model string) Tokenizer {
+	tokenizerMu.Lock()
+	defer tokenizerMu.Unlock()
+
+	if t, ok := tokenizerCache[model]; ok {
+		return t
+	}
+
+	var t Tokenizer
+	enc, err := tiktoken.GetEncoding("cl100k_base")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading tiktoken encoding, falling back to approximate token counting:", err)
+		t = approxTokenizer{}
+	} else {
+		t = tiktokenTokenizer{enc: enc, exact: isOpenAIModel(model)}
+	}
+
+	tokenizerCache[model] = t
+	return t
+}
+
+// enforcedMaxTokens computes the real prompt token count for model/prompt
+// and clamps the requested completion length so prompt+completion doesn't
+// exceed contextLength, instead of trusting the client's max_tokens as-is.
+// The completion length is never clamped below 1, so a prompt that alone
+// fills the context still gets a 1-token completion.
+// For models whose token count is only a cl100k_base approximation, it
+// holds back approxTokenSafetyMargin rather than enforcing the limit as if
+// the count were exact.
+func enforcedMaxTokens(model, prompt string, requested, contextLength, defaultTokens int) (maxLength int, promptTokens int) {
+	tokenizer := tokenizerForModel(model)
+	promptTokens = tokenizer.CountTokens(prompt)
+
+	maxLength = requested
+	if maxLength <= 0 {
+		maxLength = defaultTokens
+	}
+
+	available := contextLength - promptTokens
+	if !tokenizer.Exact() {
+		available -= approxTokenSafetyMargin
+	}
+	if available < 1 {
+		available = 1
+	}
+	if maxLength > available {
+		maxLength = available
+	}
+	return maxLength, promptTokens
+}
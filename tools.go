@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// openAITool is a single entry of the OpenAI `tools` array.
+type openAITool struct {
+	Type     string            `json:"type"`
+	Function openAIFunctionDef `json:"function"`
+}
+
+type openAIFunctionDef struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// openAIToolCall is a single entry of the `tool_calls` array the proxy emits
+// when it detects the model produced a function call.
+type openAIToolCall struct {
+	ID       string             `json:"id"`
+	Type     string             `json:"type"`
+	Function openAIToolCallFunc `json:"function"`
+}
+
+type openAIToolCallFunc struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// resolveToolChoice applies OpenAI's tool_choice semantics to the declared
+// tools, returning the subset that should actually be offered to the model:
+// none of them for "none", all of them for "auto"/unset/anything else, and
+// just the one forced function for {"type":"function","function":{"name":...}}.
+func resolveToolChoice(toolChoice interface{}, tools []openAITool) []openAITool {
+	switch v := toolChoice.(type) {
+	case string:
+		if v == "none" {
+			return nil
+		}
+		return tools
+	case map[string]interface{}:
+		fn, _ := v["function"].(map[string]interface{})
+		name, _ := fn["name"].(string)
+		for _, t := range tools {
+			if t.Function.Name == name {
+				return []openAITool{t}
+			}
+		}
+		return tools
+	default:
+		return tools
+	}
+}
+
+// buildToolSystemPrompt renders a system-prompt message describing the JSON
+// schema of every declared tool, so the model knows what functions it can
+// call and what arguments they take.
+func buildToolSystemPrompt(tools []openAITool) string {
+	var sb strings.Builder
+	sb.WriteString("You can call the following functions. When you need to call one, respond with a single JSON object of the form {\"name\": <function name>, \"arguments\": <arguments object>} and nothing else.\n\n")
+	for _, t := range tools {
+		sb.WriteString(fmt.Sprintf("Function %q: %s\n", t.Function.Name, t.Function.Description))
+		if len(t.Function.Parameters) > 0 {
+			sb.WriteString("Parameters schema: " + string(t.Function.Parameters) + "\n")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// toolsGrammar builds a single GBNF grammar that constrains generation to a
+// JSON object matching one of the declared tools' call shape, so Horde's
+// llama.cpp workers can enforce it via grammar-constrained sampling.
+func toolsGrammar(tools []openAITool) (string, error) {
+	var alternatives []string
+	var rules []string
+	for _, t := range tools {
+		var schema map[string]interface{}
+		if len(t.Function.Parameters) > 0 {
+			if err := json.Unmarshal(t.Function.Parameters, &schema); err != nil {
+				return "", fmt.Errorf("invalid parameters schema for tool %q: %w", t.Function.Name, err)
+			}
+		}
+		argsRuleName := "args-" + sanitizeRuleName(t.Function.Name)
+		rules = append(rules, jsonSchemaToGBNF(schema, argsRuleName))
+		alternatives = append(alternatives, fmt.Sprintf(
+			`"{" ws "\"name\"" ws ":" ws "\"%s\"" ws "," ws "\"arguments\"" ws ":" ws %s ws "}"`,
+			t.Function.Name, argsRuleName,
+		))
+	}
+
+	var sb strings.Builder
+	sb.WriteString("root ::= " + strings.Join(alternatives, " | ") + "\n")
+	sb.WriteString(`ws ::= [ \t\n]*` + "\n")
+	for _, rule := range rules {
+		sb.WriteString(rule + "\n")
+	}
+	return sb.String(), nil
+}
+
+func sanitizeRuleName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+// jsonSchemaToGBNF converts a (subset of) JSON Schema into a named GBNF
+// rule definition, supporting object, string, number, boolean, enum, array
+// and required.
+func jsonSchemaToGBNF(schema map[string]interface{}, ruleName string) string {
+	return ruleName + " ::= " + gbnfRuleBody(schema)
+}
+
+// gbnfRuleBody translates a (subset of) JSON Schema into an inline GBNF rule
+// body: object, string, number, boolean, enum, array and required are
+// supported, which covers the shapes tool-calling schemas actually use.
+func gbnfRuleBody(schema map[string]interface{}) string {
+	if schema == nil {
+		return `"{" ws "}"`
+	}
+
+	if enumVals, ok := schema["enum"].([]interface{}); ok {
+		var alts []string
+		for _, v := range enumVals {
+			alts = append(alts, fmt.Sprintf("%q", fmt.Sprint(v)))
+		}
+		return strings.Join(alts, " | ")
+	}
+
+	schemaType, _ := schema["type"].(string)
+	switch schemaType {
+	case "object":
+		properties, _ := schema["properties"].(map[string]interface{})
+		required := map[string]bool{}
+		if req, ok := schema["required"].([]interface{}); ok {
+			for _, r := range req {
+				required[fmt.Sprint(r)] = true
+			}
+		}
+
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var requiredFields, optionalFields []string
+		for _, name := range names {
+			propSchema, _ := properties[name].(map[string]interface{})
+			field := fmt.Sprintf(`"\"%s\"" ws ":" ws %s`, name, gbnfRuleBody(propSchema))
+			if required[name] {
+				requiredFields = append(requiredFields, field)
+			} else {
+				optionalFields = append(optionalFields, field)
+			}
+		}
+
+		body := strings.Join(requiredFields, ` ws "," ws `)
+		body += optionalFieldsChain(optionalFields, body != "")
+		return `"{" ws ` + body + ` ws "}"`
+	case "array":
+		items, _ := schema["items"].(map[string]interface{})
+		return `"[" ws (` + gbnfRuleBody(items) + ` (ws "," ws ` + gbnfRuleBody(items) + `)*)? ws "]"`
+	case "number", "integer":
+		return `"-"? [0-9]+ ("." [0-9]+)?`
+	case "boolean":
+		return `"true" | "false"`
+	case "string":
+		fallthrough
+	default:
+		return `"\"" [^"]* "\""`
+	}
+}
+
+// optionalFieldsChain builds the trailing, possibly-empty sequence of
+// optional object fields, with each field's separating comma folded into
+// its own `(...)?`  group so an omitted field never leaves a dangling
+// comma behind. Because each group wraps everything that follows it, an
+// optional field can only appear if every optional field before it does
+// too -- the same prefix-contiguous restriction real GBNF JSON-schema
+// converters use, which is fine since property order is arbitrary anyway.
+// precededByField indicates whether a required field already came before
+// this chain, so the first optional field knows whether it needs a
+// leading comma.
+func optionalFieldsChain(fields []string, precededByField bool) string {
+	if len(fields) == 0 {
+		return ""
+	}
+
+	chain := ""
+	for i := len(fields) - 1; i >= 0; i-- {
+		sep := ""
+		if i > 0 || precededByField {
+			sep = `ws "," ws `
+		}
+		inner := sep + fields[i]
+		if chain != "" {
+			inner += " " + chain
+		}
+		chain = "(" + inner + ")?"
+	}
+	return " " + chain
+}
+
+// detectToolCall tries to parse the model's response text as a JSON object
+// matching one of the declared tools' call shape ({"name": ..., "arguments":
+// ...}). It returns ok=false if the text isn't a recognized tool call, in
+// which case the caller should treat it as plain assistant content.
+func detectToolCall(text string, tools []openAITool) (openAIToolCall, bool) {
+	text = strings.TrimSpace(text)
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start == -1 || end == -1 || end < start {
+		return openAIToolCall{}, false
+	}
+
+	var call struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal([]byte(text[start:end+1]), &call); err != nil {
+		return openAIToolCall{}, false
+	}
+
+	for _, t := range tools {
+		if t.Function.Name == call.Name {
+			return openAIToolCall{
+				ID:   "call_" + sanitizeRuleName(call.Name),
+				Type: "function",
+				Function: openAIToolCallFunc{
+					Name:      call.Name,
+					Arguments: string(call.Arguments),
+				},
+			}, true
+		}
+	}
+	return openAIToolCall{}, false
+}
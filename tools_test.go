@@ -0,0 +1,83 @@
+package main
+
+import "testing"
+
+func TestGBNFRuleBodyObjectOptionalFieldSeparator(t *testing.T) {
+	stringRule := `"\"" [^"]* "\""`
+	numberRule := `"-"? [0-9]+ ("." [0-9]+)?`
+
+	cases := []struct {
+		name   string
+		schema map[string]interface{}
+		want   string
+	}{
+		{
+			name: "required then optional",
+			schema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"name": map[string]interface{}{"type": "string"},
+					"age":  map[string]interface{}{"type": "number"},
+				},
+				"required": []interface{}{"name"},
+			},
+			want: `"{" ws "\"name\"" ws ":" ws ` + stringRule +
+				` (ws "," ws "\"age\"" ws ":" ws ` + numberRule + `)? ws "}"`,
+		},
+		{
+			name: "required field sorts before optional field",
+			schema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"age":  map[string]interface{}{"type": "number"},
+					"name": map[string]interface{}{"type": "string"},
+				},
+				"required": []interface{}{"age"},
+			},
+			want: `"{" ws "\"age\"" ws ":" ws ` + numberRule +
+				` (ws "," ws "\"name\"" ws ":" ws ` + stringRule + `)? ws "}"`,
+		},
+		{
+			name: "all optional",
+			schema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"a": map[string]interface{}{"type": "string"},
+					"b": map[string]interface{}{"type": "string"},
+				},
+			},
+			want: `"{" ws  ("\"a\"" ws ":" ws ` + stringRule +
+				` (ws "," ws "\"b\"" ws ":" ws ` + stringRule + `)?)? ws "}"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := gbnfRuleBody(c.schema); got != c.want {
+				t.Errorf("gbnfRuleBody mismatch:\n got:  %s\n want: %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestGBNFRuleBodyScalars(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema map[string]interface{}
+		want   string
+	}{
+		{"string", map[string]interface{}{"type": "string"}, `"\"" [^"]* "\""`},
+		{"number", map[string]interface{}{"type": "number"}, `"-"? [0-9]+ ("." [0-9]+)?`},
+		{"boolean", map[string]interface{}{"type": "boolean"}, `"true" | "false"`},
+		{"enum", map[string]interface{}{"enum": []interface{}{"a", "b"}}, `"a" | "b"`},
+		{"nil schema", nil, `"{" ws "}"`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := gbnfRuleBody(c.schema); got != c.want {
+				t.Errorf("gbnfRuleBody(%v) = %q, want %q", c.schema, got, c.want)
+			}
+		})
+	}
+}